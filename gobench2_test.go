@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// setFlags sets command-line flags for a test and restores them after it.
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		previous := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatalf("flag.Set(%q, %q): %v", name, value, err)
+		}
+		t.Cleanup(func() { flag.Set(name, previous) })
+	}
+}
+
+func TestBuildRequestBareGet(t *testing.T) {
+	setFlags(t, map[string]string{"u": "http://127.0.0.1/", "m": "GET", "r": "1"})
+	configuration := NewConfiguration()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	configuration.buildRequest(req, configuration.targets[0], newClientState(configuration, 0))
+
+	if value := req.Header.Peek("Authorization"); value != nil {
+		t.Errorf("Authorization = %q, want none", value)
+	}
+	if value := req.Header.Peek("geolocation"); value != nil {
+		t.Errorf("geolocation = %q, want none", value)
+	}
+	if body := req.Body(); len(body) != 0 {
+		t.Errorf("body = %q, want none", body)
+	}
+	if method := string(req.Header.Method()); method != "GET" {
+		t.Errorf("method = %q, want GET", method)
+	}
+}
+
+func TestReadTargetsSkipsBlankAndCommentLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	data := "# targets\n\nhttp://127.0.0.1/a\n   \n  # indented comment\nPOST http://127.0.0.1/b\n\t\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := readTargets(path)
+	if err != nil {
+		t.Fatalf("readTargets: %v", err)
+	}
+
+	want := []Target{{URL: "http://127.0.0.1/a"}, {Method: "POST", URL: "http://127.0.0.1/b"}}
+	if len(targets) != len(want) {
+		t.Fatalf("got %d targets %+v, want %d", len(targets), targets, len(want))
+	}
+	for i, target := range targets {
+		if target.Method != want[i].Method || target.URL != want[i].URL {
+			t.Errorf("target %d = %+v, want %+v", i, target, want[i])
+		}
+	}
+}
+
+func TestReadLinesFromReader(t *testing.T) {
+	// Lines longer than the bufio buffer are read in parts.
+	long := "http://127.0.0.1/" + strings.Repeat("a", 8192)
+	input := "http://127.0.0.1/a\r\n\n# comment\n  http://127.0.0.1/b  \n" + long + "\nhttp://127.0.0.1/c"
+
+	lines, err := readLines(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readLines: %v", err)
+	}
+
+	want := []string{"http://127.0.0.1/a", "http://127.0.0.1/b", long, "http://127.0.0.1/c"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("readLines = %q, want %q", lines, want)
+	}
+}
+
+func TestResponseFileCompleteAfterShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responses.json")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration := &Configuration{
+		responseFile: file,
+		myClient:     &fasthttp.Client{},
+		limiter:      time.NewTicker(time.Millisecond),
+		done:         make(chan struct{}),
+	}
+
+	const clientCount, perClient = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < clientCount; i++ {
+		wg.Add(1)
+		go func(clientID int) {
+			defer wg.Done()
+			for j := 0; j < perClient; j++ {
+				writeResponse(configuration, int64(clientID*perClient+j), 200, []byte("body"), nil, "")
+			}
+		}(i)
+	}
+	wg.Wait()
+	shutdown(configuration)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != clientCount*perClient {
+		t.Fatalf("got %d lines, want %d", len(lines), clientCount*perClient)
+	}
+
+	seen := make(map[int64]bool)
+	for _, line := range lines {
+		var response ResponseData
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		seen[response.RequestNumber] = true
+	}
+	if len(seen) != clientCount*perClient {
+		t.Errorf("got %d distinct requests, want %d", len(seen), clientCount*perClient)
+	}
+
+	if _, err := file.Write([]byte("x")); err == nil {
+		t.Error("the response file is still open after shutdown")
+	}
+}
+
+func TestHTTP2ClientKeepsGzipBody(t *testing.T) {
+	compressed := gzipBody([]byte(strings.Repeat("hello ", 100)))
+	acceptEncoding := make(chan string, 1)
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding <- r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed)
+	}), &http2.Server{}))
+	defer server.Close()
+
+	httpClient := newHTTP2Client(fasthttp.Dial, nil)
+	defer httpClient.CloseIdleConnections()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(server.URL)
+
+	if err := httpClient.Do(req, resp); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if value := <-acceptEncoding; value != "" {
+		t.Errorf("Accept-Encoding = %q, want none", value)
+	}
+	if value := string(resp.Header.Peek("Content-Encoding")); value != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", value)
+	}
+	if body := resp.Body(); !bytes.Equal(body, compressed) {
+		t.Errorf("got %d body bytes, want the %d bytes on the wire", len(body), len(compressed))
+	}
+}